@@ -8,14 +8,34 @@ import (
 	"fmt"
 	"runtime"
 	"container/list"
+	"math/big"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"golang.org/x/crypto/blake2b"
+	"math"
+	"time"
+	"encoding/json"
+	"image"
+	"image/png"
 )
 
 var iterations = flag.Int("i", 1024, "number of iterations in mandelbrot")
 var workers = flag.Int("w", runtime.GOMAXPROCS(0)-1, "number of rendering workers")
 var tilesDiv = flag.Int("t", 8, "affects number of tiles, should be power of two")
 var noVSync = flag.Bool("no-vsync", false, "disables vsync")
-
-func drawQuad(x, y, w, h int, u, v, u2, v2 float) {
+var backend = flag.String("backend", "cpu", "rendering backend for tiles: cpu, gpu, or hybrid")
+var renderMode = flag.String("render", "naive", "tile rendering algorithm: naive, border, or progressive")
+var cacheMode = flag.String("cache", "off", "tile cache mode: off, memory, or disk")
+var cacheSize = flag.Int64("cache-size", 256<<20, "tile cache budget in bytes (memory or disk mode)")
+var recordPath = flag.String("record", "", "capture Camera keyframes (rect + timestamp) to this directory, written as script.json on quit")
+var replayPath = flag.String("replay", "", "replay a script.json captured with -record, headlessly, instead of opening a window")
+var replayOut = flag.String("out", "frames", "output directory for PNG frames written by -replay")
+
+func drawQuad(x, y, w, h int, u, v, u2, v2 float64) {
 	gl.Begin(gl.QUADS)
 
 	gl.TexCoord2f(gl.GLfloat(u), gl.GLfloat(v))
@@ -59,7 +79,7 @@ type Color struct {
 
 type ColorRange struct {
 	Start, End Color
-	Range float
+	Range float64
 }
 
 var (
@@ -82,22 +102,28 @@ var colorScale = [...]ColorRange{
 
 var palette []Color
 
-func interpolateColor(c1, c2 Color, f float) Color {
+// paletteVersion bumps every time buildPalette runs, so TileKey can
+// tell cached tiles rendered under an earlier palette apart from
+// current ones instead of serving stale colors.
+var paletteVersion int
+
+func interpolateColor(c1, c2 Color, f float64) Color {
 	var c Color
-	c.R = byte(float(c1.R) * f + float(c2.R) * (1.0 - f))
-	c.G = byte(float(c1.G) * f + float(c2.G) * (1.0 - f))
-	c.B = byte(float(c1.B) * f + float(c2.B) * (1.0 - f))
-	c.A = byte(float(c1.A) * f + float(c2.A) * (1.0 - f))
+	c.R = byte(float64(c1.R) * f + float64(c2.R) * (1.0 - f))
+	c.G = byte(float64(c1.G) * f + float64(c2.G) * (1.0 - f))
+	c.B = byte(float64(c1.B) * f + float64(c2.B) * (1.0 - f))
+	c.A = byte(float64(c1.A) * f + float64(c2.A) * (1.0 - f))
 	return c
 }
 
 func buildPalette() {
+	paletteVersion++
 	palette = make([]Color, *iterations + 1)
 	p := 0
 	for _, r := range colorScale {
-		n := int(r.Range * float(*iterations) + 0.5)
+		n := int(r.Range * float64(*iterations) + 0.5)
 		for i := 0; i < n && p < *iterations; i++ {
-			c := interpolateColor(r.Start, r.End, float(i) / float(n))
+			c := interpolateColor(r.Start, r.End, float64(i) / float64(n))
 			palette[p] = c
 			p++
 		}
@@ -106,19 +132,57 @@ func buildPalette() {
 }
 
 func mandelbrotAt(c complex128) Color {
-	var z complex128 = cmplx(0, 0)
-	for i := 0; i < *iterations; i++ {
+	return mandelbrotAtN(c, *iterations)
+}
+
+// mandelbrotAtN is mandelbrotAt generalized to iterate fewer than
+// *iterations steps. The progressive render passes use it to produce a
+// cheap low-quality preview before the full-iteration pass.
+func mandelbrotAtN(c complex128, iters int) Color {
+	var z complex128 = complex(0, 0)
+	for i := 0; i < iters; i++ {
 		z = z * z + c
-		if real(z) * real(z) + imag(z) * imag(z) > 4 {
-			return palette[i]
+		r2 := real(z) * real(z) + imag(z) * imag(z)
+		if r2 > 4 {
+			// smooth (fractional) escape count: mu = i+1-log(log|z|)/log(2),
+			// blended across the two bracketing palette entries, instead of
+			// the banding a plain integer palette[i] lookup shows when zoomed in
+			mu := float64(i) + 1 - math.Log(math.Log(r2)/2) / math.Log(2)
+			return blendPalette(mu)
 		}
 	}
 	return palette[*iterations]
 }
 
+// blendPalette linearly interpolates between the two palette entries
+// bracketing a fractional escape count mu.
+func blendPalette(mu float64) Color {
+	if mu < 0 {
+		mu = 0
+	}
+	lo := int(mu)
+	hi := lo + 1
+	if lo > *iterations {
+		lo = *iterations
+	}
+	if hi > *iterations {
+		hi = *iterations
+	}
+	return interpolateColor(palette[hi], palette[lo], mu - float64(lo))
+}
+
 type Rect struct {
 	X, Y float64
 	W, H float64
+
+	// CenterX, CenterY carry the rect's center at arbitrary precision.
+	// They are nil until a zoom goes deep enough that float64 can no
+	// longer resolve individual pixels (see deepZoomGuardW), at which
+	// point Camera.Zoom/Pan and rectFromSelection start populating them
+	// via withPrecision, and TileManager threads them through
+	// subdivision instead of recomputing the center from X/Y/W/H.
+	CenterX, CenterY *big.Float
+	Prec uint
 }
 
 func (self *Rect) Center() (x, y float64) {
@@ -127,6 +191,63 @@ func (self *Rect) Center() (x, y float64) {
 	return
 }
 
+// NeedsPerturbation reports whether this rect is too small for the
+// straightforward float64 path in mandelbrotProcessRequest and should
+// instead be rendered via perturbation theory.
+func (self *Rect) NeedsPerturbation() bool {
+	return self.W < perturbationThreshold
+}
+
+// bigOffset adds a float64 pixel-space delta to a big.Float value at
+// the given precision. Used when subdividing a deep-zoom Rect so that
+// child tiles inherit the parent's arbitrary-precision center instead
+// of losing precision by round-tripping through float64.
+func bigOffset(base *big.Float, delta float64, prec uint) *big.Float {
+	d := new(big.Float).SetPrec(prec).SetFloat64(delta)
+	return new(big.Float).SetPrec(prec).Add(base, d)
+}
+
+// deepZoomGuardW is how far in advance of perturbationThreshold a Rect
+// starts carrying an arbitrary-precision center (via withPrecision), so
+// a few bits of precision have already accumulated by the time
+// NeedsPerturbation actually flips true instead of starting from
+// scratch on that frame.
+const deepZoomGuardW = perturbationThreshold * 1e8
+
+// withPrecision derives r's CenterX/CenterY from parent, which may
+// itself already carry an arbitrary-precision center. This is what
+// lets Rect.CenterX/CenterY survive user-driven zooming and panning
+// (Camera.Zoom, Camera.Pan, rectFromSelection) instead of staying nil
+// forever - TileManager.ZoomRequest/MoveRequest only ever propagate an
+// existing center, they never create one from scratch.
+//
+// Shallow rects (parent has no center yet and r.W is still well above
+// perturbationThreshold) are returned unchanged to keep panning/zooming
+// at normal depth on the cheap float64-only path.
+func (r Rect) withPrecision(parent Rect) Rect {
+	if parent.CenterX == nil && r.W >= deepZoomGuardW {
+		return r
+	}
+
+	prec := parent.Prec
+	if need := uint(-math.Log2(r.W)) + 64; need > prec {
+		prec = need
+	}
+
+	px, py := parent.CenterX, parent.CenterY
+	pcx, pcy := parent.Center()
+	if px == nil {
+		px = new(big.Float).SetPrec(prec).SetFloat64(pcx)
+		py = new(big.Float).SetPrec(prec).SetFloat64(pcy)
+	}
+
+	rcx, rcy := r.Center()
+	r.CenterX = bigOffset(px, rcx-pcx, prec)
+	r.CenterY = bigOffset(py, rcy-pcy, prec)
+	r.Prec = prec
+	return r
+}
+
 type MandelbrotRequest struct {
 	Width int
 	Height int
@@ -134,7 +255,391 @@ type MandelbrotRequest struct {
 	Discarder <-chan bool
 }
 
+//-------------------------------------------------------------------------
+// Perturbation-theory deep zoom
+//-------------------------------------------------------------------------
+
+// perturbationThreshold is the Rect.W below which float64 no longer has
+// enough mantissa bits to tell neighbouring pixels apart; past this
+// point mandelbrotProcessRequest switches to iterating a perturbation
+// delta around a single arbitrary-precision reference orbit instead of
+// iterating each pixel's full orbit directly.
+const perturbationThreshold = 1e-13
+
+// glitchTolerance bounds how close |Z_n+Delta_n| may get to |Z_n|
+// before the perturbation iteration is considered to have glitched,
+// meaning the reference orbit has diverged too far from the pixel's
+// true orbit to trust the result.
+const glitchTolerance = 1e-6
+
+// glitchBlockSize is the pixel-space block size glitched pixels are
+// batched into for re-rendering: one fresh reference orbit is computed
+// per block instead of per pixel, since computeReferenceOrbit is
+// expensive and glitches tend to cover contiguous regions rather than
+// isolated pixels.
+const glitchBlockSize = 8
+
+// referenceOrbit is the high-precision orbit Z_{n+1} = Z_n^2 + C0,
+// rounded to double precision, plus the power-series coefficients
+// (A, B, C) of Delta_n =~ A_n*d + B_n*d^2 + C_n*d^3 used to skip the
+// early iterations for pixels close to the reference.
+type referenceOrbit struct {
+	Z []complex128
+	A []complex128
+	B []complex128
+	C []complex128
+}
+
+// computeReferenceOrbit iterates Z_{n+1} = Z_n^2 + C0 around
+// centerX+centerY*i using big.Float arithmetic at the given precision,
+// recording the double-precision orbit and series coefficients that
+// mandelbrotAtPerturbation iterates against.
+func computeReferenceOrbit(centerX, centerY *big.Float, prec uint, iterations int) *referenceOrbit {
+	orbit := &referenceOrbit{
+		Z: make([]complex128, iterations+1),
+		A: make([]complex128, iterations+1),
+		B: make([]complex128, iterations+1),
+		C: make([]complex128, iterations+1),
+	}
+	orbit.A[0] = complex(1, 0)
+
+	two := new(big.Float).SetPrec(prec).SetInt64(2)
+	zr := new(big.Float).SetPrec(prec)
+	zi := new(big.Float).SetPrec(prec)
+
+	for n := 0; n < iterations; n++ {
+		zrf, _ := zr.Float64()
+		zif, _ := zi.Float64()
+		z := complex(zrf, zif)
+		orbit.Z[n] = z
+
+		orbit.A[n+1] = 2*z*orbit.A[n] + 1
+		orbit.B[n+1] = 2*z*orbit.B[n] + orbit.A[n]*orbit.A[n]
+		orbit.C[n+1] = 2*z*orbit.C[n] + 2*orbit.A[n]*orbit.B[n]
+
+		zrzr := new(big.Float).SetPrec(prec).Mul(zr, zr)
+		zizi := new(big.Float).SetPrec(prec).Mul(zi, zi)
+		zrzi := new(big.Float).SetPrec(prec).Mul(zr, zi)
+
+		nzr := new(big.Float).SetPrec(prec).Sub(zrzr, zizi)
+		nzr.Add(nzr, centerX)
+
+		nzi := new(big.Float).SetPrec(prec).Mul(zrzi, two)
+		nzi.Add(nzi, centerY)
+
+		zr, zi = nzr, nzi
+	}
+	zrf, _ := zr.Float64()
+	zif, _ := zi.Float64()
+	orbit.Z[iterations] = complex(zrf, zif)
+
+	return orbit
+}
+
+// cmplxAbs2 is the squared magnitude of z; callers only ever compare
+// magnitudes against each other or against squared thresholds, so the
+// square root is never needed.
+func cmplxAbs2(z complex128) float64 {
+	r, i := real(z), imag(z)
+	return r*r + i*i
+}
+
+// seriesSkip returns the largest n whose next series term is still
+// below eps, i.e. the iteration a pixel at offset delta from the
+// reference can safely start its perturbation iteration from instead
+// of starting at n=0.
+func (orbit *referenceOrbit) seriesSkip(delta complex128, eps float64) int {
+	for n := len(orbit.A) - 1; n > 0; n-- {
+		term := orbit.A[n]*delta + orbit.B[n]*delta*delta + orbit.C[n]*delta*delta*delta
+		if cmplxAbs2(term) < eps*eps {
+			return n
+		}
+	}
+	return 0
+}
+
+// mandelbrotAtPerturbation iterates Delta_{n+1} = 2*Z_n*Delta_n +
+// Delta_n^2 + delta starting from the series-approximated term at
+// orbit.seriesSkip, escaping when |Z_n+Delta_n| > 2 with the same
+// smooth (fractional) escape count mandelbrotAtN uses, so deep-zoom
+// tiles don't band any worse than shallow ones. The second return
+// value reports a glitch: the pixel should be re-rendered against a
+// fresh reference orbit centered closer to it.
+func mandelbrotAtPerturbation(delta complex128, orbit *referenceOrbit, eps float64) (Color, bool) {
+	start := orbit.seriesSkip(delta, eps)
+	d := orbit.A[start]*delta + orbit.B[start]*delta*delta + orbit.C[start]*delta*delta*delta
+
+	for n := start; n < *iterations; n++ {
+		z := orbit.Z[n]
+		zd := z + d
+		r2 := real(zd)*real(zd) + imag(zd)*imag(zd)
+		if r2 > 4 {
+			mu := float64(n) + 1 - math.Log(math.Log(r2)/2) / math.Log(2)
+			return blendPalette(mu), false
+		}
+		if cmplxAbs2(zd) < glitchTolerance*glitchTolerance*cmplxAbs2(z) {
+			// not an escape - r2 is whatever magnitude the orbit happened
+			// to be at when it glitched, so the smooth escape-count
+			// formula below doesn't apply here. This color is only ever
+			// provisional: the glitched pixel gets overwritten once its
+			// fresh reference orbit is re-rendered.
+			return palette[n], true
+		}
+		d = 2*z*d + d*d + delta
+	}
+	return palette[*iterations], false
+}
+
+// escapeColor walks orbit.Z directly from n=0, returning the escape
+// color for the orbit's own center point. Used to re-render a glitched
+// pixel against a fresh orbit centered exactly on it (delta=0):
+// mandelbrotAtPerturbation(0, fresh, eps) would instead seriesSkip
+// straight to the last iteration, since a zero series term trivially
+// satisfies any eps bound, and always return the "never escaped"
+// color.
+func (orbit *referenceOrbit) escapeColor() Color {
+	for n := 0; n < len(orbit.Z); n++ {
+		z := orbit.Z[n]
+		r2 := real(z)*real(z) + imag(z)*imag(z)
+		if r2 > 4 {
+			mu := float64(n) + 1 - math.Log(math.Log(r2)/2) / math.Log(2)
+			return blendPalette(mu)
+		}
+	}
+	return palette[*iterations]
+}
+
+// mandelbrotProcessRequestPerturbation renders a tile via perturbation
+// theory: one high-precision reference orbit at the tile center, with
+// every pixel iterated in double precision relative to it. Pixels that
+// glitch are re-rendered in glitchBlockSize blocks, each against its
+// own fresh reference orbit centered on the block.
+func mandelbrotProcessRequestPerturbation(req *MandelbrotRequest) []byte {
+	data := make([]byte, req.Width * req.Height * 4)
+	what := req.What
+
+	prec := what.Prec
+	if prec == 0 {
+		prec = 256
+	}
+	ccx, ccy := what.X + what.W/2, what.Y + what.H/2
+	cx, cy := what.CenterX, what.CenterY
+	if cx == nil {
+		cx = new(big.Float).SetPrec(prec).SetFloat64(ccx)
+		cy = new(big.Float).SetPrec(prec).SetFloat64(ccy)
+	}
+
+	stepx := req.What.W / float64(req.Width)
+	stepy := req.What.H / float64(req.Height)
+	eps := MinFloat64(stepx, stepy) * 1e-2
+
+	orbit := computeReferenceOrbit(cx, cy, prec, *iterations)
+	var glitched []Point
+
+	for y := 0; y < req.Height; y++ {
+		dy := float64(y) * stepy + req.What.Y - ccy
+
+		for x := 0; x < req.Width; x++ {
+			dx := float64(x) * stepx + req.What.X - ccx
+			color, glitch := mandelbrotAtPerturbation(complex(dx, dy), orbit, eps)
+			if glitch {
+				glitched = append(glitched, Point{x, y})
+			}
+
+			offset := y * req.Width * 4 + x * 4
+			data[offset+0] = color.R
+			data[offset+1] = color.G
+			data[offset+2] = color.B
+			data[offset+3] = color.A
+		}
+		_, ok := <-req.Discarder
+		if ok {
+			return nil
+		}
+	}
+
+	// Glitched pixels are re-rendered against a fresh reference, but
+	// computeReferenceOrbit is a full *iterations-long big.Float orbit -
+	// too expensive to pay per pixel when a glitch covers a whole
+	// region. Batch glitched pixels into glitchBlockSize blocks sharing
+	// one fresh reference at the block's corner, and perturb every
+	// other pixel in the block against it like the main pass does
+	// against the tile's own reference.
+	blocks := make(map[Point][]Point)
+	for _, p := range glitched {
+		key := Point{(p.X / glitchBlockSize) * glitchBlockSize, (p.Y / glitchBlockSize) * glitchBlockSize}
+		blocks[key] = append(blocks[key], p)
+	}
+
+	for block, points := range blocks {
+		gx := float64(block.X) * stepx + req.What.X
+		gy := float64(block.Y) * stepy + req.What.Y
+		gcx := bigOffset(cx, gx - ccx, prec)
+		gcy := bigOffset(cy, gy - ccy, prec)
+		fresh := computeReferenceOrbit(gcx, gcy, prec, *iterations)
+
+		for _, p := range points {
+			px := float64(p.X) * stepx + req.What.X
+			py := float64(p.Y) * stepy + req.What.Y
+			delta := complex(px-gx, py-gy)
+
+			var color Color
+			if delta == 0 {
+				// mandelbrotAtPerturbation(0, fresh, eps) would
+				// seriesSkip straight past the whole orbit - see
+				// referenceOrbit.escapeColor.
+				color = fresh.escapeColor()
+			} else {
+				color, _ = mandelbrotAtPerturbation(delta, fresh, eps)
+			}
+
+			offset := p.Y * req.Width * 4 + p.X * 4
+			data[offset+0] = color.R
+			data[offset+1] = color.G
+			data[offset+2] = color.B
+			data[offset+3] = color.A
+		}
+	}
+
+	return data
+}
+
+//-------------------------------------------------------------------------
+// Mariani-Silver border tracing
+//-------------------------------------------------------------------------
+
+// borderTileThreshold is the subrectangle size (in pixels, both
+// dimensions) below which mandelbrotProcessRequestBorderN falls back to
+// the naive per-pixel loop instead of recursing further.
+const borderTileThreshold = 8
+
+// mandelbrotProcessRequestBorder renders req.What with a Mariani-Silver
+// border tracer at the full *iterations count.
+func mandelbrotProcessRequestBorder(req *MandelbrotRequest) []byte {
+	return mandelbrotProcessRequestBorderN(req, *iterations)
+}
+
+// mandelbrotProcessRequestBorderN only iterates the border of each
+// subrectangle: if every border pixel escapes at the same count, the
+// interior is flood-filled with that count instead of iterated;
+// otherwise the subrectangle is recursively split in four until it is
+// no bigger than borderTileThreshold, at which point it falls back to
+// the naive per-pixel loop. This exploits the connectedness of
+// escape-time regions for an order-of-magnitude speedup on the large
+// uniform "inside" areas. Per the existing Discarder convention, the
+// cancellation check happens between subdivisions rather than per row.
+func mandelbrotProcessRequestBorderN(req *MandelbrotRequest, iters int) []byte {
+	data := make([]byte, req.Width * req.Height * 4)
+	stepx := req.What.W / float64(req.Width)
+	stepy := req.What.H / float64(req.Height)
+
+	at := func(x, y int) Color {
+		c := complex(float64(x) * stepx + req.What.X, float64(y) * stepy + req.What.Y)
+		return mandelbrotAtN(c, iters)
+	}
+	put := func(x, y int, color Color) {
+		offset := y * req.Width * 4 + x * 4
+		data[offset+0] = color.R
+		data[offset+1] = color.G
+		data[offset+2] = color.B
+		data[offset+3] = color.A
+	}
+
+	var recurse func(x0, y0, x1, y1 int) bool
+	recurse = func(x0, y0, x1, y1 int) bool {
+		_, ok := <-req.Discarder
+		if ok {
+			return false
+		}
+
+		w, h := x1-x0, y1-y0
+		if w <= borderTileThreshold || h <= borderTileThreshold {
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					put(x, y, at(x, y))
+				}
+			}
+			return true
+		}
+
+		first := at(x0, y0)
+		uniform := true
+		visit := func(x, y int) {
+			c := at(x, y)
+			put(x, y, c)
+			if c != first {
+				uniform = false
+			}
+		}
+		for x := x0; x < x1; x++ {
+			visit(x, y0)
+			visit(x, y1-1)
+		}
+		for y := y0 + 1; y < y1-1; y++ {
+			visit(x0, y)
+			visit(x1-1, y)
+		}
+
+		if uniform {
+			for y := y0 + 1; y < y1-1; y++ {
+				for x := x0 + 1; x < x1-1; x++ {
+					put(x, y, first)
+				}
+			}
+			return true
+		}
+
+		midx, midy := x0+w/2, y0+h/2
+		return recurse(x0, y0, midx, midy) &&
+			recurse(midx, y0, x1, midy) &&
+			recurse(x0, midy, midx, y1) &&
+			recurse(midx, midy, x1, y1)
+	}
+
+	if !recurse(0, 0, req.Width, req.Height) {
+		return nil
+	}
+	return data
+}
+
+// progressivePasses are the iteration counts mandelbrotProcessRequestProgressive
+// renders in sequence, each pass reusing the cheap border tracer and
+// replacing the previous pass's upload once it lands.
+func progressivePasses() []int {
+	return []int{MaxInt(*iterations/16, 1), MaxInt(*iterations/4, 1), *iterations}
+}
+
+// mandelbrotProcessRequestProgressive renders req.What as a sequence of
+// increasingly accurate border-traced passes, sending each one over out
+// so Tile.ApplyData can upload a coarse version first and refine it,
+// rather than waiting for the single final-quality pass.
+//
+// A pass discarded mid-sequence (the tile got re-requested before
+// finishing) still sends a Final result with nil Data, same as the
+// non-progressive path always sends exactly one result per request:
+// MandelbrotService.Done blocks on exactly one receive per request, and
+// a discard that returned here without sending anything would leave it
+// blocked forever waiting for a result that was never coming.
+func mandelbrotProcessRequestProgressive(req *MandelbrotRequest, out chan<- *MandelbrotResult) {
+	passes := progressivePasses()
+	for i, iters := range passes {
+		data := mandelbrotProcessRequestBorderN(req, iters)
+		if data == nil {
+			out <- &MandelbrotResult{nil, true}
+			return
+		}
+		out <- &MandelbrotResult{data, i == len(passes)-1}
+	}
+}
+
 func mandelbrotProcessRequest(req *MandelbrotRequest) []byte {
+	if req.What.NeedsPerturbation() {
+		return mandelbrotProcessRequestPerturbation(req)
+	}
+	if *renderMode == "border" || *renderMode == "progressive" {
+		return mandelbrotProcessRequestBorder(req)
+	}
+
 	data := make([]byte, req.Width * req.Height * 4)
 	stepx := req.What.W / float64(req.Width)
 	stepy := req.What.H / float64(req.Height)
@@ -144,7 +649,7 @@ func mandelbrotProcessRequest(req *MandelbrotRequest) []byte {
 
 		for x := 0; x < req.Width; x++ {
 			r := float64(x) * stepx + req.What.X
-			c := cmplx(r, i)
+			c := complex(r, i)
 
 			offset := y * req.Width * 4 + x * 4
 			color := mandelbrotAt(c)
@@ -162,12 +667,25 @@ func mandelbrotProcessRequest(req *MandelbrotRequest) []byte {
 
 }
 
-func mandelbrotService(in <-chan *MandelbrotRequest) <-chan []byte {
-	out := make(chan []byte)
+// MandelbrotResult is one frame produced for a MandelbrotRequest. Final
+// is false for the intermediate passes of a progressive render and true
+// for the one (and, outside progressive mode, only) frame that
+// completes the request.
+type MandelbrotResult struct {
+	Data []byte
+	Final bool
+}
+
+func mandelbrotService(in <-chan *MandelbrotRequest) <-chan *MandelbrotResult {
+	out := make(chan *MandelbrotResult)
 	go func() {
 		for {
 			request := <-in
-			out <- mandelbrotProcessRequest(request)
+			if *renderMode == "progressive" && !request.What.NeedsPerturbation() {
+				mandelbrotProcessRequestProgressive(request, out)
+			} else {
+				out <- &MandelbrotResult{mandelbrotProcessRequest(request), true}
+			}
 		}
 	}()
 	return out
@@ -179,7 +697,7 @@ func mandelbrotService(in <-chan *MandelbrotRequest) <-chan []byte {
 
 type MandelbrotService struct {
 	In chan<- *MandelbrotRequest
-	Out <-chan []byte
+	Out <-chan *MandelbrotResult
 	Tile *Tile // non-nil, means service is busy
 	LastRequest *MandelbrotRequest
 }
@@ -202,24 +720,431 @@ func (self *MandelbrotService) Request(req *MandelbrotRequest, tile *Tile) bool
 	return false
 }
 
-// returns (data, associated tile) on success
-// (nil, nil) on failure
-func (self *MandelbrotService) Done() ([]byte, *Tile) {
-	if data, ok := <-self.Out; ok {
-		t := self.Tile
-		self.Tile = nil
+// see MandelbrotWorker.Done
+func (self *MandelbrotService) Done() ([]byte, *Tile, *MandelbrotRequest) {
+	if res, ok := <-self.Out; ok {
 		if _, ok := <-self.LastRequest.Discarder; ok {
-			return nil, nil
+			self.Tile = nil
+			return nil, nil, nil
 		}
-		return data, t
+		if !res.Final {
+			self.Tile.ApplyData(res.Data, false)
+			return nil, nil, nil
+		}
+		t, req := self.Tile, self.LastRequest
+		self.Tile = nil
+		return res.Data, t, req
 	}
-	return nil, nil
+	return nil, nil, nil
 }
 
 func (self *MandelbrotService) Busy() bool {
 	return self.Tile != nil
 }
 
+//-------------------------------------------------------------------------
+// MandelbrotWorker
+//-------------------------------------------------------------------------
+
+// MandelbrotWorker is the protocol a tile-rendering backend must
+// implement to be managed by MandelbrotQueue. MandelbrotService (CPU,
+// one goroutine per worker) and GPUMandelbrotService (GPU, pumped from
+// the main thread) both implement it, so -backend=hybrid can mix the
+// two freely in MandelbrotQueue.Services.
+type MandelbrotWorker interface {
+	Request(req *MandelbrotRequest, tile *Tile) bool
+	// Done reports the completed (data, tile, request) triple once the
+	// in-flight request's final pass has landed, or (nil, nil, nil)
+	// otherwise. The request is returned alongside the tile so callers
+	// can key a TileCache.Put off it without re-deriving it from tile
+	// state.
+	Done() ([]byte, *Tile, *MandelbrotRequest)
+	Busy() bool
+}
+
+//-------------------------------------------------------------------------
+// GPUMandelbrotService
+//-------------------------------------------------------------------------
+
+// mandelbrotFragmentShader mirrors mandelbrotAt on the GPU: it walks
+// the same escape-time loop per-pixel, computes the same smooth
+// (fractional) escape count mandelbrotAtN does, and blends the two
+// bracketing palette entries via a continuous texture1D coordinate -
+// palette's TEXTURE_MIN/MAG_FILTER are LINEAR, so the hardware sampler
+// does the same interpolation blendPalette does on the CPU - instead of
+// banding at a flat per-iteration lookup. Pixels that never escape use
+// the same coordinate (1.0) a non-escaping float(i)/float(iterations)
+// lookup would, landing on the same final palette entry as
+// mandelbrotAtN's fallback return.
+const mandelbrotFragmentShader = `
+uniform vec2 rectOrigin;
+uniform vec2 rectSize;
+uniform vec2 viewportSize;
+uniform int iterations;
+uniform sampler1D palette;
+
+void main() {
+	vec2 c = rectOrigin + (gl_FragCoord.xy / viewportSize) * rectSize;
+	vec2 z = vec2(0.0, 0.0);
+	int i;
+	for (i = 0; i < iterations; i++) {
+		z = vec2(z.x*z.x - z.y*z.y, 2.0*z.x*z.y) + c;
+		if (dot(z, z) > 4.0) {
+			break;
+		}
+	}
+	if (i == iterations) {
+		gl_FragColor = texture1D(palette, 1.0);
+		return;
+	}
+	float mu = float(i) + 1.0 - log(log(dot(z, z)) / 2.0) / log(2.0);
+	gl_FragColor = texture1D(palette, mu / float(iterations));
+}
+`
+
+const mandelbrotVertexShader = `
+void main() {
+	gl_Position = gl_Vertex;
+}
+`
+
+func compileShader(kind gl.GLenum, src string) gl.GLuint {
+	shader := gl.CreateShader(kind)
+	gl.ShaderSource(shader, src)
+	gl.CompileShader(shader)
+	if gl.GetShaderi(shader, gl.COMPILE_STATUS) == 0 {
+		panic("Failed to compile mandelbrot shader: " + gl.GetShaderInfoLog(shader))
+	}
+	return shader
+}
+
+func compileMandelbrotProgram() gl.GLuint {
+	program := gl.CreateProgram()
+	gl.AttachShader(program, compileShader(gl.VERTEX_SHADER, mandelbrotVertexShader))
+	gl.AttachShader(program, compileShader(gl.FRAGMENT_SHADER, mandelbrotFragmentShader))
+	gl.LinkProgram(program)
+	if gl.GetProgrami(program, gl.LINK_STATUS) == 0 {
+		panic("Failed to link mandelbrot shader program: " + gl.GetProgramInfoLog(program))
+	}
+	return program
+}
+
+// uploadPaletteTexture mirrors the current palette slice into a 1D
+// texture so the fragment shader can sample it the same way
+// mandelbrotAt indexes it on the CPU.
+func uploadPaletteTexture(tex gl.GLuint) {
+	data := make([]byte, len(palette)*4)
+	for i, c := range palette {
+		data[i*4+0] = c.R
+		data[i*4+1] = c.G
+		data[i*4+2] = c.B
+		data[i*4+3] = c.A
+	}
+	gl.BindTexture(gl.TEXTURE_1D, tex)
+	gl.TexParameteri(gl.TEXTURE_1D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_1D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexImage1D(gl.TEXTURE_1D, 0, gl.RGBA, gl.GLsizei(len(palette)), 0, gl.RGBA,
+		      gl.UNSIGNED_BYTE, unsafe.Pointer(&data[0]))
+}
+
+// GPUMandelbrotService renders tiles with a GLSL fragment shader bound
+// to an FBO instead of a CPU goroutine. Request is called synchronously
+// from MandelbrotQueue.Update, already on the main/GL thread, so it
+// only records the pending request; the actual draw-and-readback
+// happens in Pump, which the main loop must call explicitly between
+// TileManager.Update and TileManager.Draw.
+type GPUMandelbrotService struct {
+	program gl.GLuint
+	fbo gl.GLuint
+	outTex gl.GLuint
+	paletteTex gl.GLuint
+
+	pending *MandelbrotRequest
+	pendingTile *Tile
+	result []byte
+	resultTile *Tile
+}
+
+func NewGPUMandelbrotService() *GPUMandelbrotService {
+	self := new(GPUMandelbrotService)
+	self.program = compileMandelbrotProgram()
+	gl.GenFramebuffers(1, &self.fbo)
+	gl.GenTextures(1, &self.paletteTex)
+	uploadPaletteTexture(self.paletteTex)
+	return self
+}
+
+func (self *GPUMandelbrotService) Busy() bool {
+	return self.pendingTile != nil
+}
+
+func (self *GPUMandelbrotService) Request(req *MandelbrotRequest, tile *Tile) bool {
+	if self.Busy() {
+		return false
+	}
+	self.pending = req
+	self.pendingTile = tile
+	return true
+}
+
+// see MandelbrotWorker.Done
+func (self *GPUMandelbrotService) Done() ([]byte, *Tile, *MandelbrotRequest) {
+	if self.resultTile == nil {
+		return nil, nil, nil
+	}
+	data, tile, req := self.result, self.resultTile, self.pending
+	self.result, self.resultTile, self.pending = nil, nil, nil
+	if _, ok := <-req.Discarder; ok {
+		return nil, nil, nil
+	}
+	return data, tile, req
+}
+
+// Pump renders the pending request (if any) into outTex via the
+// fragment shader and reads it back into result. Must run on the
+// thread holding the GL context.
+func (self *GPUMandelbrotService) Pump() {
+	if self.pendingTile == nil || self.resultTile != nil {
+		return
+	}
+	req := self.pending
+
+	reuploadTexture(&self.outTex, req.Width, req.Height, make([]byte, req.Width*req.Height*4))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, self.fbo)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, self.outTex, 0)
+
+	gl.UseProgram(self.program)
+	gl.Uniform2f(gl.GetUniformLocation(self.program, "rectOrigin"), gl.GLfloat(req.What.X), gl.GLfloat(req.What.Y))
+	gl.Uniform2f(gl.GetUniformLocation(self.program, "rectSize"), gl.GLfloat(req.What.W), gl.GLfloat(req.What.H))
+	gl.Uniform2f(gl.GetUniformLocation(self.program, "viewportSize"), gl.GLfloat(req.Width), gl.GLfloat(req.Height))
+	gl.Uniform1i(gl.GetUniformLocation(self.program, "iterations"), gl.GLint(*iterations))
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_1D, self.paletteTex)
+	gl.Uniform1i(gl.GetUniformLocation(self.program, "palette"), 0)
+
+	gl.Viewport(0, 0, gl.GLsizei(req.Width), gl.GLsizei(req.Height))
+
+	// drawQuad emits window-space pixel coordinates, which relies on
+	// the global gl.Ortho set up in main() for the 512x512 window - not
+	// valid here, where the viewport above is sized to the tile being
+	// rendered into outTex. Draw a plain clip-space fullscreen quad
+	// instead, under a projection/modelview reset to identity so
+	// mandelbrotVertexShader's gl_Position = gl_Vertex lands each
+	// corner exactly on the framebuffer edge.
+	gl.MatrixMode(gl.PROJECTION)
+	gl.PushMatrix()
+	gl.LoadIdentity()
+	gl.MatrixMode(gl.MODELVIEW)
+	gl.PushMatrix()
+	gl.LoadIdentity()
+
+	gl.Begin(gl.QUADS)
+	gl.Vertex2f(gl.GLfloat(-1), gl.GLfloat(-1))
+	gl.Vertex2f(gl.GLfloat(1), gl.GLfloat(-1))
+	gl.Vertex2f(gl.GLfloat(1), gl.GLfloat(1))
+	gl.Vertex2f(gl.GLfloat(-1), gl.GLfloat(1))
+	gl.End()
+
+	gl.MatrixMode(gl.PROJECTION)
+	gl.PopMatrix()
+	gl.MatrixMode(gl.MODELVIEW)
+	gl.PopMatrix()
+
+	data := make([]byte, req.Width*req.Height*4)
+	gl.ReadPixels(0, 0, gl.GLsizei(req.Width), gl.GLsizei(req.Height), gl.RGBA, gl.UNSIGNED_BYTE,
+		      unsafe.Pointer(&data[0]))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+
+	self.result = data
+	self.resultTile = self.pendingTile
+	self.pendingTile = nil
+}
+
+//-------------------------------------------------------------------------
+// TileCache
+//-------------------------------------------------------------------------
+
+// TileKey identifies a cacheable tile by everything that affects its
+// pixels: the rect being rendered (at whatever precision it carries),
+// the resolution, the iteration count, the palette in use, and which
+// backend rendered it - GPUMandelbrotService colors via a flat texture1D
+// lookup while the CPU path blends smoothly (see mandelbrotAt), so the
+// two backends produce different pixels for what would otherwise be an
+// identical key, and -backend=hybrid must not serve one's tile to the
+// other out of the cache.
+type TileKey struct {
+	What Rect
+	W, H int
+	Iterations int
+	PaletteVersion int
+	GPU bool
+}
+
+// encode is a stable binary encoding of the key. Deep-zoom rects carry
+// their center in CenterX/CenterY at arbitrary precision (see
+// Rect.NeedsPerturbation); encoding those big.Float bits directly,
+// rather than X/Y, keeps two rects that only differ past float64
+// precision from colliding on the same cache filename.
+func (self TileKey) encode() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, int64(self.W))
+	binary.Write(&buf, binary.LittleEndian, int64(self.H))
+	binary.Write(&buf, binary.LittleEndian, int64(self.Iterations))
+	binary.Write(&buf, binary.LittleEndian, int64(self.PaletteVersion))
+	binary.Write(&buf, binary.LittleEndian, self.GPU)
+	if self.What.CenterX != nil {
+		buf.WriteString(self.What.CenterX.Text('p', 0))
+		buf.WriteString(self.What.CenterY.Text('p', 0))
+	} else {
+		binary.Write(&buf, binary.LittleEndian, self.What.X)
+		binary.Write(&buf, binary.LittleEndian, self.What.Y)
+	}
+	binary.Write(&buf, binary.LittleEndian, self.What.W)
+	binary.Write(&buf, binary.LittleEndian, self.What.H)
+	return buf.Bytes()
+}
+
+// Filename returns the key's BLAKE2b digest, hex-encoded, for use as a
+// stable cache filename.
+func (self TileKey) Filename() string {
+	sum := blake2b.Sum256(self.encode())
+	return fmt.Sprintf("%x", sum)
+}
+
+// cacheEntry is one TileCache LRU node. In disk mode Data is mmap'd
+// straight from the backing file; in memory mode it's a plain
+// heap-allocated slice.
+type cacheEntry struct {
+	key string
+	data []byte
+	size int64
+	// mmapped reports whether data came from syscall.Mmap (Get,
+	// re-opening a disk-mode entry from a previous process) as opposed
+	// to a plain heap slice (Put, disk or memory mode alike - Put never
+	// mmaps what it writes, it just keeps the caller's slice around).
+	// Only mmapped entries may be passed to syscall.Munmap on eviction.
+	mmapped bool
+}
+
+// TileCache stores rendered tile RGBA bytes keyed by TileKey so
+// re-visiting a location does not recompute it. In disk mode, tiles are
+// memory-mapped on Get so reuploadTexture can hand the bytes straight
+// to glTexImage2D without an extra copy. Both modes evict
+// least-recently-used entries once curBytes exceeds *cacheSize.
+type TileCache struct {
+	dir string
+	index map[string]*list.Element
+	lru *list.List
+	curBytes int64
+}
+
+func cacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "gomandel")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".cache", "gomandel")
+}
+
+func NewTileCache() *TileCache {
+	self := new(TileCache)
+	self.index = make(map[string]*list.Element)
+	self.lru = list.New()
+	if *cacheMode == "disk" {
+		self.dir = cacheDir()
+		os.MkdirAll(self.dir, 0755)
+	}
+	return self
+}
+
+// Get returns the cached tile for key, memory-mapping it from disk the
+// first time it is seen in a process in disk mode.
+func (self *TileCache) Get(key TileKey) ([]byte, bool) {
+	if *cacheMode == "off" {
+		return nil, false
+	}
+
+	name := key.Filename()
+	if e, ok := self.index[name]; ok {
+		self.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).data, true
+	}
+	if *cacheMode != "disk" {
+		return nil, false
+	}
+
+	f, err := os.Open(filepath.Join(self.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil || st.Size() == 0 {
+		return nil, false
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(st.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, false
+	}
+
+	self.insert(name, data, st.Size(), true)
+	return data, true
+}
+
+// Put stores data under key: written to disk (and then mmap'd for
+// subsequent Gets) in disk mode, kept in the heap in memory mode.
+func (self *TileCache) Put(key TileKey, data []byte) {
+	if *cacheMode == "off" {
+		return
+	}
+	name := key.Filename()
+	if _, ok := self.index[name]; ok {
+		return
+	}
+
+	if *cacheMode == "disk" {
+		if err := ioutil.WriteFile(filepath.Join(self.dir, name), data, 0644); err != nil {
+			return
+		}
+	}
+	self.insert(name, data, int64(len(data)), false)
+}
+
+func (self *TileCache) insert(name string, data []byte, size int64, mmapped bool) {
+	e := self.lru.PushFront(&cacheEntry{name, data, size, mmapped})
+	self.index[name] = e
+	self.curBytes += size
+	self.evict(e)
+}
+
+// evict drops least-recently-used entries until curBytes is back under
+// *cacheSize, skipping protect (the entry insert just added): if that
+// entry alone is bigger than *cacheSize, evicting it here would
+// syscall.Munmap or drop the very data insert's caller is about to
+// return or hand to the GL upload, before they ever get to use it.
+// Disk-mode entries are unlinked on disk; mmapped ones are additionally
+// unmapped. Memory-mode entries just drop out of the index for the GC
+// to reclaim.
+func (self *TileCache) evict(protect *list.Element) {
+	for self.curBytes > *cacheSize {
+		back := self.lru.Back()
+		if back == nil || back == protect {
+			return
+		}
+		entry := back.Value.(*cacheEntry)
+		self.lru.Remove(back)
+		delete(self.index, entry.key)
+		self.curBytes -= entry.size
+		if entry.mmapped {
+			syscall.Munmap(entry.data)
+		}
+		if *cacheMode == "disk" {
+			os.Remove(filepath.Join(self.dir, entry.key))
+		}
+	}
+}
+
 //-------------------------------------------------------------------------
 // MandelbrotQueue
 //-------------------------------------------------------------------------
@@ -230,15 +1155,37 @@ type MandelbrotQueueElem struct {
 }
 
 type MandelbrotQueue struct {
-	Services []*MandelbrotService
+	Services []MandelbrotWorker
 	Queue *list.List
+	Cache *TileCache
+}
+
+// tileKeyFor derives a TileKey from a request's own size and rect - the
+// same ones every resolution pass (small LOD, full LOD, ...) sets on
+// its own MandelbrotRequest - plus the render settings that also affect
+// a tile's pixels, including which backend is about to render (or just
+// rendered) it.
+func tileKeyFor(req *MandelbrotRequest, gpu bool) TileKey {
+	return TileKey{req.What, req.Width, req.Height, *iterations, paletteVersion, gpu}
 }
 
 func NewMandelbrotQueue() *MandelbrotQueue {
 	self := new(MandelbrotQueue)
-	self.Services = make([]*MandelbrotService, *workers)
-	for i, _ := range self.Services {
-		self.Services[i] = NewMandelbrotService()
+	self.Cache = NewTileCache()
+	switch *backend {
+	case "gpu":
+		self.Services = []MandelbrotWorker{NewGPUMandelbrotService()}
+	case "hybrid":
+		self.Services = make([]MandelbrotWorker, *workers+1)
+		for i := 0; i < *workers; i++ {
+			self.Services[i] = NewMandelbrotService()
+		}
+		self.Services[*workers] = NewGPUMandelbrotService()
+	default:
+		self.Services = make([]MandelbrotWorker, *workers)
+		for i, _ := range self.Services {
+			self.Services[i] = NewMandelbrotService()
+		}
 	}
 	self.Queue = list.New()
 	return self
@@ -250,25 +1197,51 @@ func (self *MandelbrotQueue) Enqueue(w, h int, what Rect, discarder <-chan bool,
 	self.Queue.PushBack(e)
 }
 
-func (self *MandelbrotQueue) FreeService() *MandelbrotService {
+func (self *MandelbrotQueue) FreeService() MandelbrotWorker {
 	// we're ready if the queue is not empty and there is at least one non-busy service
 	if self.Queue.Len() == 0 {
 		return nil
 	}
 
+	// in hybrid mode deep zooms need the CPU's perturbation-theory path
+	// (see Rect.NeedsPerturbation), so route them away from the GPU
+	// worker regardless of which service happens to be free first
+	front := self.Queue.Front().Value.(*MandelbrotQueueElem)
+	needsCPU := *backend == "hybrid" && front.Request.What.NeedsPerturbation()
+
 	for _, s := range self.Services {
-		if !s.Busy() {
-			return s
+		if s.Busy() {
+			continue
 		}
+		if needsCPU {
+			if _, isGPU := s.(*GPUMandelbrotService); isGPU {
+				continue
+			}
+		}
+		return s
 	}
 	return nil
 }
 
+// PumpGPU drives the draw-and-readback step of any GPU-backed workers.
+// It must run on the thread holding the GL context, so the main loop
+// calls it explicitly between TileManager.Update and TileManager.Draw
+// rather than it happening inside Update itself.
+func (self *MandelbrotQueue) PumpGPU() {
+	for _, s := range self.Services {
+		if gs, ok := s.(*GPUMandelbrotService); ok {
+			gs.Pump()
+		}
+	}
+}
+
 func (self *MandelbrotQueue) Update() {
 	for _, s := range self.Services {
-		data, tile := s.Done()
+		data, tile, req := s.Done()
 		if data != nil {
-			tile.ApplyData(data)
+			tile.ApplyData(data, true)
+			_, isGPU := s.(*GPUMandelbrotService)
+			self.Cache.Put(tileKeyFor(req, isGPU), data)
 		}
 	}
 	for {
@@ -280,6 +1253,11 @@ func (self *MandelbrotQueue) Update() {
 			if _, ok := <-e.Request.Discarder; ok {
 				continue
 			}
+			_, isGPU := s.(*GPUMandelbrotService)
+			if cached, ok := self.Cache.Get(tileKeyFor(e.Request, isGPU)); ok {
+				e.Tile.ApplyData(cached, true)
+				continue
+			}
 			r := s.Request(e.Request, e.Tile)
 			if !r {
 				panic("Busy?")
@@ -375,11 +1353,11 @@ func rectFromSelection(p1, p2 Point, scrw, scrh int, cur Rect) Rect {
 	r.Y = float64(min.Y) * stepy + cur.Y
 	r.W = float64(max.X - min.X) * stepx
 	r.H = float64(max.Y - min.Y) * stepy
-	return r
+	return r.withPrecision(cur)
 }
 
 type TexCoords struct {
-	TX, TY, TX2, TY2 float
+	TX, TY, TX2, TY2 float64
 }
 
 func texCoordsFromSelection(p1, p2 Point, w, h int, tcold TexCoords) (tc TexCoords) {
@@ -398,13 +1376,13 @@ func texCoordsFromSelection(p1, p2 Point, w, h int, tcold TexCoords) (tc TexCoor
 	modx := tcold.TX2 - tcold.TX
 	mody := tcold.TY2 - tcold.TY
 
-	stepx := (1 / float(w)) * modx
-	stepy := (1 / float(h)) * mody
+	stepx := (1 / float64(w)) * modx
+	stepy := (1 / float64(h)) * mody
 
-	tc.TX = tcold.TX + float(min.X) * stepx
-	tc.TX2 = tcold.TX + float(max.X) * stepx
-	tc.TY = tcold.TY + float(min.Y) * stepy
-	tc.TY2 = tcold.TY + float(max.Y) * stepy
+	tc.TX = tcold.TX + float64(min.X) * stepx
+	tc.TX2 = tcold.TX + float64(max.X) * stepx
+	tc.TY = tcold.TY + float64(min.Y) * stepy
+	tc.TY2 = tcold.TY + float64(max.Y) * stepy
 	return
 }
 
@@ -471,18 +1449,33 @@ func (self *Tile) Request(x, y int, what Rect) {
 	self.Queue.Enqueue(self.SW, self.SH, what, self.Discarder, self)
 }
 
-func (self *Tile) ApplyData(data []byte) {
-	switch self.CurrentLOD {
-	case -1:
+// ApplyData uploads a rendered frame. final marks the frame that
+// completes the tile's outstanding request; progressive rendering (see
+// -render=progressive) calls this once per intermediate pass with
+// final=false before the call that actually finishes the request, so a
+// tile visibly sharpens across N passes instead of the previous fixed
+// small-then-full 2-LOD pop.
+//
+// Which texture slot a frame belongs to is decided by its own size
+// (len(data)), not CurrentLOD: the small-LOD request is itself rendered
+// progressively, so its intermediate (final=false) passes must keep
+// landing in Texture[0] and must not advance CurrentLOD or enqueue the
+// full-size request - only its final pass does, same as non-progressive
+// rendering's single small-LOD frame always did.
+func (self *Tile) ApplyData(data []byte, final bool) {
+	switch {
+	case self.CurrentLOD == -1 && len(data) == self.SW*self.SH*4:
 		reuploadTexture(&self.Texture[0], self.SW, self.SH, data)
-		self.CurrentLOD = 0
-		self.Queue.Enqueue(self.W, self.H, self.What, self.Discarder, self)
-	case 0:
+		if final {
+			self.CurrentLOD = 0
+			self.Queue.Enqueue(self.W, self.H, self.What, self.Discarder, self)
+		}
+	case len(data) == self.W*self.H*4:
 		reuploadTexture(&self.Texture[1], self.W, self.H, data)
 		self.CurrentLOD = 1
-		self.Enqueued = false
-	case 1:
-		panic("unreachable")
+		if final {
+			self.Enqueued = false
+		}
 	default:
 		panic("unreachable")
 	}
@@ -493,7 +1486,7 @@ func (self *Tile) Draw() {
 	case -1:
 		// TODO: draw single color
 		r, i := self.What.Center()
-		c := cmplx(r, i)
+		c := complex(r, i)
 		color := mandelbrotAt(c)
 		gl.BindTexture(gl.TEXTURE_2D, 0)
 		gl.Color3ub(gl.GLubyte(color.R), gl.GLubyte(color.G), gl.GLubyte(color.B))
@@ -557,7 +1550,7 @@ func (self *TileManager) ZoomRequest(what *Rect) {
 	ty2 := int((oy + what.H) / tileh)
 	if tx1 == -2147483648 {
 		fmt.Printf("Too close, sorry, zooming out...\n")
-		*what = Rect{-1.5,-1.5,3,3}
+		*what = Rect{X: -1.5, Y: -1.5, W: 3, H: 3}
 		self.ZoomRequest(what)
 		return
 	}
@@ -578,6 +1571,11 @@ func (self *TileManager) ZoomRequest(what *Rect) {
 			r.Y = float64(y) * tileh + (-1.5)
 			r.W = tilew
 			r.H = tileh
+			if what.CenterX != nil {
+				r.CenterX = bigOffset(what.CenterX, r.X-what.X, what.Prec)
+				r.CenterY = bigOffset(what.CenterY, r.Y-what.Y, what.Prec)
+				r.Prec = what.Prec
+			}
 			px := int((r.X - what.X) / pixw)
 			py := int((r.Y - what.Y) / pixh)
 			self.Tiles[i].Request(px, py, r)
@@ -643,6 +1641,11 @@ func (self *TileManager) MoveRequest(what Rect) {
 			r.Y = float64(y) * tileh + (-1.5)
 			r.W = tilew
 			r.H = tileh
+			if what.CenterX != nil {
+				r.CenterX = bigOffset(what.CenterX, r.X-what.X, what.Prec)
+				r.CenterY = bigOffset(what.CenterY, r.Y-what.Y, what.Prec)
+				r.Prec = what.Prec
+			}
 			if !overlaps(r, self.LastWhat) {
 				// this tile was newly introduced
 				px := int((r.X - what.X) / pixw)
@@ -665,25 +1668,270 @@ func (self *TileManager) Update() {
 	self.Queue.Update()
 }
 
+// PumpGPU drives any GPU-backed workers; see MandelbrotQueue.PumpGPU.
+func (self *TileManager) PumpGPU() {
+	self.Queue.PumpGPU()
+}
+
 func (self *TileManager) Draw() {
 	for i := 0; i < len(self.Tiles); i++ {
 		self.Tiles[i].Draw()
 	}
 }
 
-func moveRectBy(what Rect, e, s Point, w, h int) Rect {
-	pixw := what.W / float64(w)
-	pixh := what.H / float64(h)
+//-------------------------------------------------------------------------
+// Camera
+//-------------------------------------------------------------------------
 
-	var r Rect
-	r.X = what.X + float64(e.X - s.X) * pixw
-	r.Y = what.Y + float64(e.Y - s.Y) * pixh
-	r.W = what.W
-	r.H = what.H
-	if r.X < -1.5 || r.Y < -1.5 || (r.X + r.W) > 1.5 || (r.Y + r.H) > 1.5 {
-		return what
+// tweenSpeed is the fraction of the remaining Target-Current gap closed
+// on every Step call; at a 60Hz frame rate this settles within a few
+// hundred milliseconds of a wheel/pan gesture stopping.
+const tweenSpeed = 0.2
+
+// settleEpsilon is how close Current.W must get to Target.W (as a
+// fraction of Target.W) before Step considers the tween settled.
+// reZoomRatio is how far Current.W may drift from the rect of the last
+// ZoomRequest before Step issues another one mid-glide. Step rides out
+// everything in between on MoveRequest, which repositions and reuses
+// already-rendered tiles instead of freeing them: ZoomRequest discards
+// every tile outright, and at tweenSpeed's pace Current.W alone
+// crosses a tight band like [0.98, 1.02] on nearly every frame of an
+// active glide, so gating on that band turned a single wheel zoom into
+// a run of full tile-queue resets, flashing blank placeholders the
+// whole way down instead of gliding over the tiles already in flight.
+const settleEpsilon = 0.01
+const reZoomRatio = 1.5
+
+// Camera owns the rect the user is driving (Target, via mouse wheel
+// zoom and drag pan) and the rect actually being displayed (Current),
+// tweening the latter toward the former every Step instead of snapping
+// straight to it. Step re-issues ZoomRequest/MoveRequest against tm
+// whenever Current has moved enough to matter.
+type Camera struct {
+	tm *TileManager
+	Target Rect
+	Current Rect
+	lastZoomed Rect
+}
+
+func NewCamera(tm *TileManager, initial Rect) *Camera {
+	self := new(Camera)
+	self.tm = tm
+	self.Target = initial
+	self.Current = initial
+	self.lastZoomed = initial
+	return self
+}
+
+// Snap sets both Target and Current to r immediately, bypassing the
+// tween - used by the discrete click-drag rectangle and middle-click
+// reset interactions, which should not glide.
+func (self *Camera) Snap(r Rect) {
+	self.Target = r
+	self.Current = r
+	self.lastZoomed = r
+	self.tm.ZoomRequest(&self.Current)
+}
+
+// Zoom scales Target around (fx, fy), a point in [0,1]x[0,1] screen
+// space, by factor (less than 1 zooms in, greater than 1 zooms out) -
+// driven by the mouse wheel.
+func (self *Camera) Zoom(fx, fy, factor float64) {
+	old := self.Target
+	cx := old.X + fx*old.W
+	cy := old.Y + fy*old.H
+	next := old
+	next.W *= factor
+	next.H *= factor
+	next.X = cx - fx*next.W
+	next.Y = cy - fy*next.H
+	self.Target = next.withPrecision(old)
+}
+
+// Pan moves Target by a fraction of its own size - driven by
+// smooth-scroll pan gestures - unless that would push it outside the
+// renderable [-1.5,1.5] domain, in which case the pan is dropped.
+func (self *Camera) Pan(dx, dy float64) {
+	old := self.Target
+	x := old.X + dx*old.W
+	y := old.Y + dy*old.H
+	if x < -1.5 || y < -1.5 || (x+old.W) > 1.5 || (y+old.H) > 1.5 {
+		return
+	}
+	next := old
+	next.X, next.Y = x, y
+	self.Target = next.withPrecision(old)
+}
+
+// Step tweens Current toward Target and re-issues ZoomRequest (once the
+// tween has settled near Target, or drifted far enough from the last
+// ZoomRequest that a long glide needs re-subdividing) or MoveRequest
+// (otherwise, every other frame of the glide) against tm. Call once
+// per frame.
+func (self *Camera) Step() {
+	self.Current.X += (self.Target.X - self.Current.X) * tweenSpeed
+	self.Current.Y += (self.Target.Y - self.Current.Y) * tweenSpeed
+	self.Current.W += (self.Target.W - self.Current.W) * tweenSpeed
+	self.Current.H += (self.Target.H - self.Current.H) * tweenSpeed
+
+	settled := math.Abs(self.Current.W-self.Target.W) < settleEpsilon*self.Target.W
+	ratio := self.Current.W / self.lastZoomed.W
+	if settled || ratio < 1/reZoomRatio || ratio > reZoomRatio {
+		self.lastZoomed = self.Current
+		self.tm.ZoomRequest(&self.Current)
+	} else {
+		self.tm.MoveRequest(self.Current)
+	}
+}
+
+//-------------------------------------------------------------------------
+// Recorder / replay
+//-------------------------------------------------------------------------
+
+// Keyframe is one recorded Camera.Current sample. Time is seconds
+// since the Recorder's first Sample.
+type Keyframe struct {
+	Rect Rect
+	Time float64
+}
+
+// Recorder accumulates Keyframes during an interactive -record session
+// and writes them as a replayable script.json on quit.
+type Recorder struct {
+	dir string
+	start time.Time
+	frames []Keyframe
+}
+
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{dir: dir}
+}
+
+func (self *Recorder) Sample(r Rect) {
+	if self.start.IsZero() {
+		self.start = time.Now()
+	}
+	self.frames = append(self.frames, Keyframe{r, time.Since(self.start).Seconds()})
+}
+
+// Save writes the recorded keyframes as dir/script.json, ready for
+// -replay.
+func (self *Recorder) Save() error {
+	if err := os.MkdirAll(self.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(self.frames, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(self.dir, "script.json"), data, 0644)
+}
+
+// replayFPS is the frame rate runReplay samples the recorded keyframes
+// at when producing PNG frames for offline video assembly.
+const replayFPS = 30.0
+
+// lerpCenter interpolates the arbitrary-precision center between two
+// keyframe Rects at fraction f (0 at a, 1 at b), for use where at least
+// one of a, b carries one. A side with no recorded center falls back to
+// its float64 Center(), the same bootstrap withPrecision uses, so
+// replaying across the point a recording first went deep doesn't
+// silently drop back to float64 for every frame on the shallow side.
+func lerpCenter(a, b Rect, f float64, prec uint) (cx, cy *big.Float) {
+	ax, ay := a.CenterX, a.CenterY
+	if ax == nil {
+		acx, acy := a.Center()
+		ax = new(big.Float).SetPrec(prec).SetFloat64(acx)
+		ay = new(big.Float).SetPrec(prec).SetFloat64(acy)
+	}
+	bx, by := b.CenterX, b.CenterY
+	if bx == nil {
+		bcx, bcy := b.Center()
+		bx = new(big.Float).SetPrec(prec).SetFloat64(bcx)
+		by = new(big.Float).SetPrec(prec).SetFloat64(bcy)
+	}
+	fb := new(big.Float).SetPrec(prec).SetFloat64(f)
+	cx = new(big.Float).SetPrec(prec).Sub(bx, ax)
+	cx.Mul(cx, fb).Add(cx, ax)
+	cy = new(big.Float).SetPrec(prec).Sub(by, ay)
+	cy.Mul(cy, fb).Add(cy, ay)
+	return
+}
+
+// interpolateKeyframes linearly interpolates the Rect between the two
+// recorded keyframes bracketing t, clamping to the first/last keyframe
+// outside the recorded range. If either bracketing keyframe carries an
+// arbitrary-precision center, the interpolated Rect gets one too (via
+// lerpCenter) instead of silently falling back to float64 for every
+// frame that isn't itself a recorded keyframe.
+func interpolateKeyframes(frames []Keyframe, t float64) Rect {
+	if t <= frames[0].Time {
+		return frames[0].Rect
+	}
+	for i := 1; i < len(frames); i++ {
+		if t <= frames[i].Time {
+			a, b := frames[i-1], frames[i]
+			f := (t - a.Time) / (b.Time - a.Time)
+			r := Rect{
+				X: a.Rect.X + (b.Rect.X-a.Rect.X)*f,
+				Y: a.Rect.Y + (b.Rect.Y-a.Rect.Y)*f,
+				W: a.Rect.W + (b.Rect.W-a.Rect.W)*f,
+				H: a.Rect.H + (b.Rect.H-a.Rect.H)*f,
+			}
+			if a.Rect.CenterX != nil || b.Rect.CenterX != nil {
+				prec := a.Rect.Prec
+				if b.Rect.Prec > prec {
+					prec = b.Rect.Prec
+				}
+				r.Prec = prec
+				r.CenterX, r.CenterY = lerpCenter(a.Rect, b.Rect, f, prec)
+			}
+			return r
+		}
+	}
+	return frames[len(frames)-1].Rect
+}
+
+// runReplay loads a script.json written by -record from scriptDir,
+// interpolates between its keyframes at replayFPS, and renders each
+// frame to outDir/frame-NNNNN.png via mandelbrotProcessRequest - with
+// no SDL window, for offline video assembly.
+func runReplay(scriptDir, outDir string) {
+	data, err := ioutil.ReadFile(filepath.Join(scriptDir, "script.json"))
+	if err != nil {
+		panic(err)
+	}
+	var frames []Keyframe
+	if err := json.Unmarshal(data, &frames); err != nil {
+		panic(err)
+	}
+	if len(frames) == 0 {
+		return
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		panic(err)
+	}
+	buildPalette()
+
+	const width, height = 512, 512
+	total := int(frames[len(frames)-1].Time*replayFPS) + 1
+
+	for i := 0; i < total; i++ {
+		rect := interpolateKeyframes(frames, float64(i)/replayFPS)
+		req := &MandelbrotRequest{width, height, rect, make(chan bool, 1)}
+		pix := mandelbrotProcessRequest(req)
+
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		copy(img.Pix, pix)
+
+		f, err := os.Create(filepath.Join(outDir, fmt.Sprintf("frame-%05d.png", i)))
+		if err != nil {
+			panic(err)
+		}
+		png.Encode(f, img)
+		f.Close()
 	}
-	return r
 }
 
 //-------------------------------------------------------------------------
@@ -693,6 +1941,10 @@ func moveRectBy(what Rect, e, s Point, w, h int) Rect {
 func main() {
 	runtime.LockOSThread()
 	flag.Parse()
+	if *replayPath != "" {
+		runReplay(*replayPath, *replayOut)
+		return
+	}
 	if *workers <= 0 {
 		*workers = 1
 	}
@@ -728,11 +1980,16 @@ func main() {
 	var dnd3 bool = false
 	var dndStart Point
 	var dndEnd Point
-	initialRect := Rect{-1.5,-1.5,3,3}
-	rect := initialRect
+	initialRect := Rect{X: -1.5, Y: -1.5, W: 3, H: 3}
 
 	tm := NewTileManager(512, 512)
-	tm.ZoomRequest(&rect)
+	camera := NewCamera(tm, initialRect)
+	camera.Snap(initialRect)
+
+	var recorder *Recorder
+	if *recordPath != "" {
+		recorder = NewRecorder(*recordPath)
+	}
 
 	running := true
 
@@ -743,13 +2000,17 @@ func main() {
 			case sdl.QUIT:
 				running = false
 			case sdl.MOUSEBUTTONDOWN:
-				dndDragging = true
 				dndStart.X = int(e.MouseButton().X)
 				dndStart.Y = int(e.MouseButton().Y)
 				dndEnd = dndStart
-				if e.MouseButton().Button == 3 {
+				switch e.MouseButton().Button {
+				case 3:
 					dnd3 = true
-				} else {
+				case 4: // mouse wheel up: zoom in around the cursor
+					camera.Zoom(float64(dndStart.X)/512, float64(dndStart.Y)/512, 0.9)
+				case 5: // mouse wheel down: zoom out around the cursor
+					camera.Zoom(float64(dndStart.X)/512, float64(dndStart.Y)/512, 1.0/0.9)
+				default:
 					dndDragging = true
 				}
 			case sdl.MOUSEBUTTONUP:
@@ -759,11 +2020,9 @@ func main() {
 
 				switch e.MouseButton().Button {
 				case 1:
-					rect = rectFromSelection(dndStart, dndEnd, 512, 512, rect)
-					tm.ZoomRequest(&rect)
+					camera.Snap(rectFromSelection(dndStart, dndEnd, 512, 512, camera.Current))
 				case 2:
-					rect = initialRect
-					tm.ZoomRequest(&rect)
+					camera.Snap(initialRect)
 				case 3:
 					dnd3 = false
 				}
@@ -771,8 +2030,7 @@ func main() {
 				if dnd3 {
 					dndEnd.X = int(e.MouseMotion().X)
 					dndEnd.Y = int(e.MouseMotion().Y)
-					rect = moveRectBy(rect, dndStart, dndEnd, 512, 512)
-					tm.MoveRequest(rect)
+					camera.Pan(float64(dndEnd.X-dndStart.X)/512, float64(dndEnd.Y-dndStart.Y)/512)
 					dndStart = dndEnd
 				} else if dndDragging {
 					dndEnd.X = int(e.MouseMotion().X)
@@ -780,7 +2038,12 @@ func main() {
 				}
 			}
 		}
+		camera.Step()
+		if recorder != nil {
+			recorder.Sample(camera.Current)
+		}
 		tm.Update()
+		tm.PumpGPU()
 		gl.Clear(gl.COLOR_BUFFER_BIT)
 		tm.Draw()
 		gl.BindTexture(gl.TEXTURE_2D, 0)
@@ -789,4 +2052,10 @@ func main() {
 		}
 		sdl.GL_SwapBuffers()
 	}
+
+	if recorder != nil {
+		if err := recorder.Save(); err != nil {
+			fmt.Printf("failed to save recording: %s\n", err)
+		}
+	}
 }